@@ -0,0 +1,222 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DBOptions tunes the bulk ingestion path used by CreateChirpsBatch and
+// Ingest. A zero DBOptions uses the defaults documented on each field.
+type DBOptions struct {
+	// MaxConcurrentEncoders bounds how many chirp bodies are validated
+	// concurrently within a single batch. Defaults to 32.
+	MaxConcurrentEncoders int
+
+	// BatchFlushSize is how many chirps Ingest accumulates before
+	// committing them to disk as one batch. Defaults to 32.
+	BatchFlushSize int
+}
+
+const (
+	defaultMaxConcurrentEncoders = 32
+	defaultBatchFlushSize        = 32
+)
+
+func (o DBOptions) withDefaults() DBOptions {
+	if o.MaxConcurrentEncoders <= 0 {
+		o.MaxConcurrentEncoders = defaultMaxConcurrentEncoders
+	}
+	if o.BatchFlushSize <= 0 {
+		o.BatchFlushSize = defaultBatchFlushSize
+	}
+	return o
+}
+
+// WithDBOptions sets the bulk ingestion tunables for a DB.
+func WithDBOptions(opts DBOptions) Option {
+	return func(db *DB) error {
+		db.opts = opts
+		return nil
+	}
+}
+
+// validateChirpBody trims whitespace and rejects empty chirp bodies.
+func validateChirpBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", fmt.Errorf("database: chirp body must not be empty")
+	}
+	return body, nil
+}
+
+// CreateChirpsBatch validates bodies concurrently, bounded by
+// DBOptions.MaxConcurrentEncoders, then assigns IDs and commits every
+// chirp to the log as a single coalesced write under one lock
+// acquisition. Results are returned in the same order as bodies; if any
+// body is invalid, no chirp in the batch is written.
+func (db *DB) CreateChirpsBatch(bodies []string) ([]Chirp, error) {
+	opts := db.opts.withDefaults()
+
+	validated := make([]string, len(bodies))
+	errs := make([]error, len(bodies))
+
+	encodeToken := make(chan struct{}, opts.MaxConcurrentEncoders)
+	var wg sync.WaitGroup
+	for i, body := range bodies {
+		wg.Add(1)
+		encodeToken <- struct{}{}
+		go func(i int, body string) {
+			defer wg.Done()
+			defer func() { <-encodeToken }()
+			validated[i], errs[i] = validateChirpBody(body)
+		}(i, body)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return db.commitBatch(validated)
+}
+
+// commitBatch assigns sequential IDs to bodies and appends them to the
+// log as one contiguous write, updating the index for the whole batch
+// under a single lock acquisition.
+func (db *DB) commitBatch(bodies []string) ([]Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if db.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	offset, err := db.size()
+	if err != nil {
+		return nil, err
+	}
+
+	chirps := make([]Chirp, len(bodies))
+	var buf bytes.Buffer
+	for i, body := range bodies {
+		id := db.next + i
+		frame, err := encodeRecord(db, record{ID: id, Body: body})
+		if err != nil {
+			return nil, err
+		}
+
+		db.index[id] = offset + int64(buf.Len())
+		buf.Write(frame)
+		chirps[i] = Chirp{ID: id, Body: body}
+	}
+
+	if _, err := db.file.WriteAt(buf.Bytes(), offset); err != nil {
+		return nil, err
+	}
+	db.next += len(bodies)
+
+	return chirps, nil
+}
+
+// batchResult is the outcome of committing one batch, delivered through a
+// dedicated channel so Ingest can emit it in submission order regardless
+// of which batch's commit actually finishes first.
+type batchResult struct {
+	chirps []Chirp
+	err    error
+}
+
+// Ingest streams chirp bodies from in, accumulating them into batches of
+// DBOptions.BatchFlushSize and committing each batch with
+// CreateChirpsBatch. Up to DBOptions.MaxConcurrentEncoders batches may be
+// mid-commit at once, so later batches can be validated while earlier
+// ones are still being written to disk, but out always receives chirps in
+// the same order their bodies arrived on in, even when a later batch's
+// commit finishes before an earlier one's. Cancelling ctx stops accepting
+// new input; Ingest flushes any partial batch and drains in-flight
+// commits before closing both returned channels.
+func (db *DB) Ingest(ctx context.Context, in <-chan string) (<-chan Chirp, <-chan error) {
+	opts := db.opts.withDefaults()
+
+	out := make(chan Chirp)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errc)
+
+		fileToken := make(chan struct{}, opts.MaxConcurrentEncoders)
+		order := make(chan chan batchResult, opts.MaxConcurrentEncoders)
+		var wg sync.WaitGroup
+
+		// drain emits each batch's result to out/errc in the order
+		// batches were submitted, blocking on a still-in-flight earlier
+		// batch even if a later one has already finished committing.
+		drained := make(chan struct{})
+		go func() {
+			defer close(drained)
+			for resultCh := range order {
+				res := <-resultCh
+				if res.err != nil {
+					select {
+					case errc <- res.err:
+					default:
+					}
+					continue
+				}
+				for _, c := range res.chirps {
+					out <- c
+				}
+			}
+		}()
+
+		batch := make([]string, 0, opts.BatchFlushSize)
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			bodies := batch
+			batch = make([]string, 0, opts.BatchFlushSize)
+
+			resultCh := make(chan batchResult, 1)
+			order <- resultCh
+
+			wg.Add(1)
+			fileToken <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-fileToken }()
+
+				chirps, err := db.CreateChirpsBatch(bodies)
+				resultCh <- batchResult{chirps: chirps, err: err}
+			}()
+		}
+
+	loop:
+		for {
+			select {
+			case <-ctx.Done():
+				break loop
+			case body, ok := <-in:
+				if !ok {
+					break loop
+				}
+				batch = append(batch, body)
+				if len(batch) >= opts.BatchFlushSize {
+					flush()
+				}
+			}
+		}
+		flush()
+		wg.Wait()
+		close(order)
+		<-drained
+	}()
+
+	return out, errc
+}
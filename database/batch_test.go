@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateChirpsBatchOrderAndIDs(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "db.log"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+
+	bodies := []string{"one", "two", "three", "four", "five"}
+	chirps, err := db.CreateChirpsBatch(bodies)
+	if err != nil {
+		t.Fatalf("CreateChirpsBatch: %v", err)
+	}
+
+	for i, c := range chirps {
+		if c.Body != bodies[i] {
+			t.Fatalf("chirp %d: got body %q, want %q", i, c.Body, bodies[i])
+		}
+		if c.ID != i+1 {
+			t.Fatalf("chirp %d: got id %d, want %d", i, c.ID, i+1)
+		}
+	}
+
+	got, err := db.GetChirps()
+	if err != nil {
+		t.Fatalf("GetChirps: %v", err)
+	}
+	if len(got) != len(bodies) {
+		t.Fatalf("GetChirps returned %d chirps, want %d", len(got), len(bodies))
+	}
+}
+
+func TestCreateChirpsBatchRejectsEmptyBody(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "db.log"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+
+	if _, err := db.CreateChirpsBatch([]string{"ok", "   "}); err == nil {
+		t.Fatal("expected an error for an empty chirp body, got nil")
+	}
+
+	chirps, err := db.GetChirps()
+	if err != nil {
+		t.Fatalf("GetChirps: %v", err)
+	}
+	if len(chirps) != 0 {
+		t.Fatalf("a failed batch must not commit any chirps, found %d", len(chirps))
+	}
+}
+
+// TestIngestPreservesInputOrder pins down the fix for a reordering bug:
+// Ingest used to emit chirps in whatever order their batch commits
+// happened to finish, rather than the order their bodies arrived on the
+// input channel. With MaxConcurrentEncoders > 1, a later batch with less
+// work can easily finish before an earlier one.
+func TestIngestPreservesInputOrder(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "db.log"),
+		WithDBOptions(DBOptions{MaxConcurrentEncoders: 8, BatchFlushSize: 2}))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+
+	const n = 40
+	in := make(chan string, n)
+	want := make([]string, n)
+	for i := 0; i < n; i++ {
+		body := string(rune('a' + i%26))
+		in <- body
+		want[i] = body
+	}
+	close(in)
+
+	out, errc := db.Ingest(context.Background(), in)
+
+	var got []string
+	for c := range out {
+		got = append(got, c.Body)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d chirps, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("chirp %d out of order: got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
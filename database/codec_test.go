@@ -0,0 +1,138 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// codecs under test, keyed by a short label for test names.
+func allCodecs() map[string]Codec {
+	return map[string]Codec{
+		"json":    JSONCodec(),
+		"bson":    BSONCodec(),
+		"msgpack": MsgPackCodec(),
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for name, codec := range allCodecs() {
+		t.Run(name, func(t *testing.T) {
+			rec := record{ID: 7, Body: "hello, chirp", Tombstone: false}
+
+			data, err := codec.Marshal(rec)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+
+			var got record
+			if err := codec.Unmarshal(data, &got); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if got != rec {
+				t.Fatalf("round-trip mismatch: got %+v, want %+v", got, rec)
+			}
+		})
+	}
+}
+
+func TestCodecPersistsAcrossReopen(t *testing.T) {
+	for name, codec := range allCodecs() {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db.log")
+
+			db, err := NewDB(path, WithCodec(codec))
+			if err != nil {
+				t.Fatalf("NewDB: %v", err)
+			}
+			for _, body := range []string{"one", "two", "three"} {
+				if _, err := db.CreateChirp(body); err != nil {
+					t.Fatalf("CreateChirp(%q): %v", body, err)
+				}
+			}
+			if err := db.file.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+
+			// Reopen without specifying a codec: initHeader must
+			// auto-detect it from the file's magic header.
+			reopened, err := NewDB(path)
+			if err != nil {
+				t.Fatalf("NewDB (auto-detect): %v", err)
+			}
+			defer reopened.file.Close()
+
+			if reopened.codec.ID() != codec.ID() {
+				t.Fatalf("auto-detected codec id %d, want %d", reopened.codec.ID(), codec.ID())
+			}
+
+			chirps, err := reopened.GetChirps()
+			if err != nil {
+				t.Fatalf("GetChirps: %v", err)
+			}
+			if len(chirps) != 3 {
+				t.Fatalf("got %d chirps, want 3: %+v", len(chirps), chirps)
+			}
+		})
+	}
+}
+
+func TestWithCodecRejectsMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.log")
+
+	db, err := NewDB(path, WithCodec(BSONCodec()))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	if _, err := db.CreateChirp("hi"); err != nil {
+		t.Fatalf("CreateChirp: %v", err)
+	}
+	if err := db.file.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if _, err := NewDB(path, WithCodec(MsgPackCodec())); err == nil {
+		t.Fatal("expected NewDB to reject a codec that doesn't match the log's header, got nil")
+	}
+}
+
+func TestMigrateConvertsCodecAndPreservesIDs(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "src.log")
+	dstPath := filepath.Join(t.TempDir(), "dst.log")
+
+	src, err := NewDB(srcPath)
+	if err != nil {
+		t.Fatalf("NewDB(src): %v", err)
+	}
+	for _, body := range []string{"a", "b", "c"} {
+		if _, err := src.CreateChirp(body); err != nil {
+			t.Fatalf("CreateChirp(%q): %v", body, err)
+		}
+	}
+	if err := src.DeleteChirp(2); err != nil {
+		t.Fatalf("DeleteChirp: %v", err)
+	}
+	if err := src.file.Close(); err != nil {
+		t.Fatalf("close src: %v", err)
+	}
+
+	if err := Migrate(srcPath, dstPath, MsgPackCodec()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	dst, err := NewDB(dstPath, WithCodec(MsgPackCodec()))
+	if err != nil {
+		t.Fatalf("NewDB(dst): %v", err)
+	}
+	defer dst.file.Close()
+
+	chirps, err := dst.GetChirps()
+	if err != nil {
+		t.Fatalf("GetChirps: %v", err)
+	}
+	if len(chirps) != 2 {
+		t.Fatalf("got %d chirps, want 2: %+v", len(chirps), chirps)
+	}
+	if chirps[0].ID != 1 || chirps[1].ID != 3 {
+		t.Fatalf("Migrate should preserve original IDs, got %+v", chirps)
+	}
+}
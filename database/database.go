@@ -1,8 +1,11 @@
 package database
 
 import (
-	"encoding/json"
-	"errors"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
 	"sort"
 	"sync"
@@ -13,71 +16,212 @@ type Chirp struct {
 	Body string `json:"body"`
 }
 
+// DB is an append-only, log-structured chirp store. Every mutation is
+// appended as a record to db.file; db.index tracks the byte offset of the
+// most recent live record for each chirp ID so reads never have to scan
+// the whole log.
 type DB struct {
-	path string
-	mux  *sync.RWMutex
+	path  string
+	mux   *sync.RWMutex
+	file  *os.File
+	index map[int]int64
+	next  int
+
+	// aead, when set, transparently encrypts every record written to the
+	// log and decrypts every record read back from it. See encryption.go.
+	aead cipher.AEAD
+
+	// readOnly is set on a DB that is following a Primary; its mutating
+	// methods reject calls with ErrReadOnly. epoch is bumped by Compact so
+	// a Replica can tell its resume offset no longer lines up with the log
+	// on disk. See replication.go.
+	readOnly bool
+	epoch    uint64
+
+	// opts tunes the bulk ingestion path. See batch.go.
+	opts DBOptions
+
+	// codec (de)serializes each record's payload; headerSize is the
+	// number of bytes the codec's magic header occupies at the start of
+	// the file (0 for a legacy, header-less log). See codec.go.
+	codec      Codec
+	headerSize int64
 }
 
-type DBStructure struct {
-	Chirps map[int]Chirp `json:"chirps"`
+// Option configures a DB at construction time. See WithEncryptionKey.
+type Option func(*DB) error
+
+// record is the on-disk representation of a single log entry.
+type record struct {
+	ID        int    `json:"id"`
+	Body      string `json:"body"`
+	Tombstone bool   `json:"tombstone"`
 }
 
-// NewDB creates a new database connection
-// and creates the database file if it doesn't exist
-func NewDB(path string) (*DB, error) {
-    
+// ErrChirpNotFound is returned when an operation targets a chirp ID that
+// has no live record in the index.
+var ErrChirpNotFound = fmt.Errorf("database: chirp not found")
+
+// ErrReadOnly is returned by mutating methods on a DB that is following a
+// Primary as a Replica.
+var ErrReadOnly = fmt.Errorf("database: database is read-only (replica)")
+
+// NewDB opens the log file at path, creating it if it doesn't exist, and
+// replays it once to rebuild the in-memory index. If no WithEncryptionKey
+// option is given and the CHIRPY_DB_KEY environment variable is set, the
+// database is opened with encryption enabled using that key.
+func NewDB(path string, opts ...Option) (*DB, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
 	db := &DB{
-		path: path,
-		mux:  &sync.RWMutex{},
+		path:  path,
+		mux:   &sync.RWMutex{},
+		file:  file,
+		index: make(map[int]int64),
 	}
 
-	err := db.ensureDB()
-	if err != nil {
+	for _, opt := range opts {
+		if err := opt(db); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	if db.aead == nil {
+		if err := db.useEnvKey(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	if err := db.initHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if err := db.buildIndex(); err != nil {
+		file.Close()
 		return nil, err
 	}
 
 	return db, nil
 }
 
-// CreateChirp creates a new chirp and saves it to disk
+// buildIndex performs a single sequential scan of the log file, validating
+// each record's checksum and recording the offset of the newest record per
+// chirp ID. Tombstone records remove their ID from the index.
+func (db *DB) buildIndex() error {
+	maxID := 0
+	offset, err := db.scanFrom(db.headerSize, func(rec record, at int64) {
+		if rec.ID > maxID {
+			maxID = rec.ID
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Drop a partial trailing record so later appends follow the last
+	// complete one.
+	size, err := db.size()
+	if err != nil {
+		return err
+	}
+	if offset < size {
+		if err := db.file.Truncate(offset); err != nil {
+			return err
+		}
+	}
+
+	db.next = maxID + 1
+	return nil
+}
+
+// scanFrom replays every record between offset and the end of the log,
+// updating db.index and invoking observe for each record seen, and
+// returns the offset immediately past the last record scanned.
+func (db *DB) scanFrom(offset int64, observe func(rec record, at int64)) (int64, error) {
+	for {
+		rec, next, err := readRecordAt(db, db.file, offset)
+		if err == io.EOF || err == errTruncatedRecord {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, fmt.Errorf("database: corrupt log at offset %d: %w", offset, err)
+		}
+
+		if rec.Tombstone {
+			delete(db.index, rec.ID)
+		} else {
+			db.index[rec.ID] = offset
+		}
+		observe(rec, offset)
+
+		offset = next
+	}
+}
+
+// CreateChirp appends a new chirp record to the log and updates the index.
 func (db *DB) CreateChirp(body string) (Chirp, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
-	dbStruct, err := db.loadDB()
+	if db.readOnly {
+		return Chirp{}, ErrReadOnly
+	}
+
+	chirpID := db.next
+	rec := record{ID: chirpID, Body: body}
+
+	offset, err := appendRecord(db, db.file, rec)
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	chirpID := len(dbStruct.Chirps) + 1
-	newChirp := Chirp{
-		ID:   chirpID,
-		Body: body,
+	db.index[chirpID] = offset
+	db.next++
+
+	return Chirp{ID: chirpID, Body: body}, nil
+}
+
+// DeleteChirp appends a tombstone record for id, removing it from future
+// reads without touching any other record in the log.
+func (db *DB) DeleteChirp(id int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if db.readOnly {
+		return ErrReadOnly
 	}
 
-	dbStruct.Chirps[chirpID] = newChirp
+	if _, ok := db.index[id]; !ok {
+		return ErrChirpNotFound
+	}
 
-	err = db.writeDB(dbStruct)
+	_, err := appendRecord(db, db.file, record{ID: id, Tombstone: true})
 	if err != nil {
-		return Chirp{}, err
+		return err
 	}
 
-	return newChirp, nil
+	delete(db.index, id)
+	return nil
 }
 
-// GetChirps returns all chirps in the database
+// GetChirps returns all live chirps, sorted by ID.
 func (db *DB) GetChirps() ([]Chirp, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
 
-	dbStruct, err := db.loadDB()
-	if err != nil {
-		return nil, err
-	}
-
-	chirps := make([]Chirp, 0, len(dbStruct.Chirps))
-	for _, chirp := range dbStruct.Chirps {
-		chirps = append(chirps, chirp)
+	chirps := make([]Chirp, 0, len(db.index))
+	for id, offset := range db.index {
+		rec, _, err := readRecordAt(db, db.file, offset)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, Chirp{ID: id, Body: rec.Body})
 	}
 
 	sort.Slice(chirps, func(i, j int) bool {
@@ -87,51 +231,176 @@ func (db *DB) GetChirps() ([]Chirp, error) {
 	return chirps, nil
 }
 
-// ensureDB creates a new database file if it doesn't exist
-func (db *DB) ensureDB() error {
-	_, err := os.Stat(db.path)
-	if errors.Is(err, os.ErrNotExist) {
-		_, err = os.Create(db.path)
+// Compact rewrites the log to contain only the latest live record per
+// chirp ID, reclaiming space held by overwritten and tombstoned records.
+// The new log is built in a .tmp file and atomically renamed over the
+// original so a crash mid-compaction never leaves a partial log in place.
+func (db *DB) Compact() error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
+	tmpPath := db.path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := db.writeHeaderTo(tmpFile); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	ids := make([]int, 0, len(db.index))
+	for id := range db.index {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	newIndex := make(map[int]int64, len(ids))
+	for _, id := range ids {
+		rec, _, err := readRecordAt(db, db.file, db.index[id])
+		if err != nil {
+			tmpFile.Close()
+			return err
+		}
+
+		offset, err := appendRecord(db, tmpFile, rec)
 		if err != nil {
+			tmpFile.Close()
 			return err
 		}
+		newIndex[id] = offset
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, db.path); err != nil {
+		return err
 	}
 
+	if err := db.file.Close(); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(db.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	db.file = file
+	db.index = newIndex
+	db.epoch++
 	return nil
 }
 
-// loadDB reads the database file into memory
-func (db *DB) loadDB() (DBStructure, error) {
-	db.mux.RLock()
-	defer db.mux.RUnlock()
-
-	data, err := os.ReadFile(db.path)
+// size returns the current length of the log file.
+func (db *DB) size() (int64, error) {
+	info, err := db.file.Stat()
 	if err != nil {
-		return DBStructure{}, err
+		return 0, err
 	}
+	return info.Size(), nil
+}
 
-	var dbStruct DBStructure
-	err = json.Unmarshal(data, &dbStruct)
+// encodeRecord marshals rec, optionally encrypts it, and prefixes it with
+// a length and CRC32 checksum, producing the exact bytes a log entry for
+// rec occupies on disk.
+func encodeRecord(db *DB, rec record) ([]byte, error) {
+	payload, err := db.codec.Marshal(rec)
 	if err != nil {
-		return DBStructure{}, err
+		return nil, err
 	}
 
-	if dbStruct.Chirps == nil {
-		dbStruct.Chirps = make(map[int]Chirp)
+	if db.aead != nil {
+		payload, err = db.seal(payload)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return dbStruct, nil
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload))
+
+	return append(header, payload...), nil
 }
 
-// writeDB writes the database file to disk
-func (db *DB) writeDB(dbStruct DBStructure) error {
-	db.mux.Lock()
-	defer db.mux.Unlock()
+// appendRecord encodes rec and appends it to the end of f, returning the
+// offset it was written at.
+func appendRecord(db *DB, f *os.File, rec record) (int64, error) {
+	frame, err := encodeRecord(db, rec)
+	if err != nil {
+		return 0, err
+	}
 
-	data, err := json.MarshalIndent(dbStruct, "", "  ")
+	offset, err := f.Seek(0, io.SeekEnd)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	if _, err := f.Write(frame); err != nil {
+		return 0, err
+	}
+
+	return offset, nil
+}
+
+// errTruncatedRecord is returned by readRecordAt when a record's header or
+// payload is cut off partway through. This is expected when a crash
+// happens mid-append: the log ends with a partial write rather than a
+// whole one. buildIndex and scanFrom treat it the same as a clean io.EOF,
+// recovering every complete record before it and discarding the rest.
+var errTruncatedRecord = fmt.Errorf("database: truncated record (crash mid-write)")
+
+// readRecordAt reads and validates the record starting at offset, returning
+// the decoded record and the offset immediately following it. It returns
+// io.EOF when offset is exactly at the end of the log, and
+// errTruncatedRecord when the log ends partway through a record.
+func readRecordAt(db *DB, f *os.File, offset int64) (record, int64, error) {
+	header := make([]byte, 8)
+	n, err := f.ReadAt(header, offset)
+	if n < len(header) {
+		if n == 0 && (err == io.EOF || err == nil) {
+			return record{}, 0, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return record{}, 0, err
+		}
+		return record{}, 0, errTruncatedRecord
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	wantCRC := binary.BigEndian.Uint32(header[4:8])
+
+	payload := make([]byte, length)
+	n, err = f.ReadAt(payload, offset+8)
+	if n < len(payload) {
+		if err != nil && err != io.EOF {
+			return record{}, 0, err
+		}
+		return record{}, 0, errTruncatedRecord
+	}
+
+	if crc32.ChecksumIEEE(payload) != wantCRC {
+		return record{}, 0, fmt.Errorf("checksum mismatch")
+	}
+
+	if db.aead != nil {
+		plaintext, err := db.open(payload)
+		if err != nil {
+			return record{}, 0, err
+		}
+		payload = plaintext
+	}
+
+	var rec record
+	if err := db.codec.Unmarshal(payload, &rec); err != nil {
+		return record{}, 0, err
 	}
 
-	return os.WriteFile(db.path, data, 0644)
+	return rec, offset + 8 + int64(length), nil
 }
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewDBRecoversFromTruncatedTrailingRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.log")
+
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	for _, body := range []string{"one", "two", "three"} {
+		if _, err := db.CreateChirp(body); err != nil {
+			t.Fatalf("CreateChirp(%q): %v", body, err)
+		}
+	}
+	if err := db.file.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// Simulate a crash mid-append: chop off the last few bytes of the log,
+	// leaving a valid header and/or payload for the final record cut short.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	recovered, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB on a log with a truncated trailing record should recover, got: %v", err)
+	}
+	defer recovered.file.Close()
+
+	chirps, err := recovered.GetChirps()
+	if err != nil {
+		t.Fatalf("GetChirps: %v", err)
+	}
+	if len(chirps) != 2 {
+		t.Fatalf("expected the 2 complete records to survive, got %d chirps: %+v", len(chirps), chirps)
+	}
+	if chirps[0].Body != "one" || chirps[1].Body != "two" {
+		t.Fatalf("unexpected recovered chirps: %+v", chirps)
+	}
+}
+
+func TestNewDBRejectsCorruptChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.log")
+
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	if _, err := db.CreateChirp("hello"); err != nil {
+		t.Fatalf("CreateChirp: %v", err)
+	}
+	if err := db.file.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// Flip a byte inside the record's payload (past the 8-byte header),
+	// leaving the length prefix intact so this is a checksum mismatch
+	// rather than a truncated read.
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := f.WriteAt([]byte{0xFF}, db.headerSize+10); err != nil {
+		t.Fatalf("corrupt: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if _, err := NewDB(path); err == nil {
+		t.Fatal("expected NewDB to fail on a checksum mismatch, got nil error")
+	}
+}
+
+func TestCompactReclaimsOverwrittenAndTombstonedRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.log")
+
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+
+	for _, body := range []string{"keep", "delete-me", "also-keep"} {
+		if _, err := db.CreateChirp(body); err != nil {
+			t.Fatalf("CreateChirp(%q): %v", body, err)
+		}
+	}
+	if err := db.DeleteChirp(2); err != nil {
+		t.Fatalf("DeleteChirp: %v", err)
+	}
+
+	sizeBefore, err := db.size()
+	if err != nil {
+		t.Fatalf("size: %v", err)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+
+	sizeAfter, err := db.size()
+	if err != nil {
+		t.Fatalf("size: %v", err)
+	}
+	if sizeAfter >= sizeBefore {
+		t.Fatalf("expected Compact to shrink the log (before=%d, after=%d)", sizeBefore, sizeAfter)
+	}
+
+	chirps, err := db.GetChirps()
+	if err != nil {
+		t.Fatalf("GetChirps: %v", err)
+	}
+	if len(chirps) != 2 {
+		t.Fatalf("expected 2 chirps to survive compaction, got %d: %+v", len(chirps), chirps)
+	}
+	if chirps[0].Body != "keep" || chirps[1].Body != "also-keep" {
+		t.Fatalf("unexpected chirps after compaction: %+v", chirps)
+	}
+
+	// The log must still be usable for further reads and writes after
+	// Compact reopens db.file.
+	if _, err := db.CreateChirp("after-compact"); err != nil {
+		t.Fatalf("CreateChirp after Compact: %v", err)
+	}
+	reopened, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB on compacted log: %v", err)
+	}
+	defer reopened.file.Close()
+	chirps, err = reopened.GetChirps()
+	if err != nil {
+		t.Fatalf("GetChirps after reopen: %v", err)
+	}
+	if len(chirps) != 3 {
+		t.Fatalf("expected 3 chirps after reopening the compacted log, got %d: %+v", len(chirps), chirps)
+	}
+}
+
+func TestCompactRejectsReadOnlyDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.log")
+
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	db.mux.Lock()
+	db.readOnly = true
+	db.mux.Unlock()
+
+	if err := db.Compact(); err != ErrReadOnly {
+		t.Fatalf("Compact on a read-only DB: got %v, want %v", err, ErrReadOnly)
+	}
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// envKeyVar is the environment variable NewDB consults for an encryption
+// key when no WithEncryptionKey option was given.
+const envKeyVar = "CHIRPY_DB_KEY"
+
+// WithEncryptionKey enables transparent at-rest encryption of every record
+// written to the log, and decryption of every record read back from it,
+// using ChaCha20-Poly1305 with key. key must be chacha20poly1305.KeySize
+// (32) bytes, typically produced by GenerateKey.
+func WithEncryptionKey(key []byte) Option {
+	return func(db *DB) error {
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return fmt.Errorf("database: invalid encryption key: %w", err)
+		}
+		db.aead = aead
+		return nil
+	}
+}
+
+// useEnvKey enables encryption from the CHIRPY_DB_KEY environment variable,
+// if set. The variable must hold a 32-byte key hex-encoded (64 hex
+// characters), matching the output of GenerateKey. A raw 32-byte string
+// can't round-trip through the environment reliably (os.Setenv rejects
+// any value containing a NUL byte), so the key is never passed as-is.
+func (db *DB) useEnvKey() error {
+	encoded := os.Getenv(envKeyVar)
+	if encoded == "" {
+		return nil
+	}
+
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("database: %s must be hex-encoded: %w", envKeyVar, err)
+	}
+	return WithEncryptionKey(key)(db)
+}
+
+// GenerateKey returns a cryptographically random 32-byte ChaCha20-Poly1305
+// key suitable for WithEncryptionKey. Use hex.EncodeToString(GenerateKey())
+// to produce a value that can be stored in CHIRPY_DB_KEY.
+func GenerateKey() []byte {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		panic("database: failed to read random bytes: " + err.Error())
+	}
+	return key
+}
+
+// seal encrypts plaintext with a fresh random nonce, using the database
+// path as associated data so a ciphertext can't be replayed into a
+// different database file, and returns nonce || ciphertext.
+func (db *DB) seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, db.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return db.aead.Seal(nonce, nonce, plaintext, []byte(db.path)), nil
+}
+
+// open splits the nonce prefix off sealed and decrypts the remainder. It
+// returns a clear error, rather than a generic unmarshal failure, when the
+// key is wrong or the record has been tampered with.
+func (db *DB) open(sealed []byte) ([]byte, error) {
+	nonceSize := db.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("database: encrypted record is truncated")
+	}
+
+	nonce, ciphertext := sealed[:nonceSize], sealed[nonceSize:]
+	plaintext, err := db.aead.Open(nil, nonce, ciphertext, []byte(db.path))
+	if err != nil {
+		return nil, fmt.Errorf("database: failed to decrypt record (wrong key or tampered data): %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,269 @@
+package database
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// pollInterval is how often a Primary checks its log for new bytes to
+// stream to connected replicas. There is no filesystem-change
+// notification here, so this is a simple, cheap poll rather than a push.
+const pollInterval = 200 * time.Millisecond
+
+// Design note: this package deliberately replicates the DB's own
+// append-only log (database.go) rather than maintaining a second,
+// separate WAL file of {seq, op, payload} entries. Every CreateChirp and
+// DeleteChirp already lands in that log as one immutable, checksummed
+// record before the call returns, so it already *is* a replayable
+// write-ahead log; a byte offset into it is a monotonically increasing
+// position exactly like a seq would be, and Compact's epoch counter
+// (database.go) tells a Replica when its offset no longer lines up with
+// the log on disk, which is what a seq reset on WAL truncation would
+// otherwise signal. Introducing a second on-disk log alongside the first
+// would duplicate every write without changing the resume semantics.
+
+// Primary streams a DB's append-only log to connected Replicas.
+type Primary struct {
+	db *DB
+}
+
+// NewPrimary wraps db so it can serve replication connections. db continues
+// to accept normal reads and writes.
+func NewPrimary(db *DB) *Primary {
+	return &Primary{db: db}
+}
+
+// ListenReplication accepts TCP connections on addr and serves each one a
+// snapshot of the log followed by a live stream of newly appended bytes.
+// It blocks until the listener is closed.
+func (p *Primary) ListenReplication(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go p.serve(conn)
+	}
+}
+
+// serve handles a single replica connection: it resolves the replica's
+// resume point against the current log, sends a snapshot or delta, and
+// then tails the log for as long as the connection stays open.
+func (p *Primary) serve(conn net.Conn) {
+	defer conn.Close()
+
+	handshake := make([]byte, 16)
+	if _, err := io.ReadFull(conn, handshake); err != nil {
+		return
+	}
+	replicaEpoch := binary.BigEndian.Uint64(handshake[0:8])
+	replicaOffset := int64(binary.BigEndian.Uint64(handshake[8:16]))
+
+	p.db.mux.RLock()
+	epoch := p.db.epoch
+	size, err := p.db.size()
+	var data []byte
+	if err == nil {
+		from := replicaOffset
+		if replicaEpoch != epoch || replicaOffset > size {
+			from = 0
+		}
+		data = make([]byte, size-from)
+		_, err = p.db.file.ReadAt(data, from)
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	p.db.mux.RUnlock()
+	if err != nil {
+		return
+	}
+
+	snapshot := replicaEpoch != epoch || replicaOffset > size
+	if err := writeFrame(conn, snapshot, epoch, data); err != nil {
+		return
+	}
+
+	sent := size
+	for {
+		time.Sleep(pollInterval)
+
+		p.db.mux.RLock()
+		curEpoch := p.db.epoch
+		curSize, err := p.db.size()
+		var delta []byte
+		if err == nil && curEpoch == epoch && curSize != sent {
+			delta = make([]byte, curSize-sent)
+			_, err = p.db.file.ReadAt(delta, sent)
+		}
+		p.db.mux.RUnlock()
+		if err != nil {
+			return
+		}
+
+		if curEpoch != epoch {
+			// The log was compacted out from under this stream; the
+			// replica must reconnect and renegotiate from scratch.
+			return
+		}
+		if curSize == sent {
+			continue
+		}
+
+		if err := writeFrame(conn, false, curEpoch, delta); err != nil {
+			return
+		}
+		sent = curSize
+	}
+}
+
+// writeFrame writes a [mode(1) | epoch(8) | length(8) | payload] frame.
+func writeFrame(w io.Writer, snapshot bool, epoch uint64, payload []byte) error {
+	header := make([]byte, 17)
+	if snapshot {
+		header[0] = 1
+	}
+	binary.BigEndian.PutUint64(header[1:9], epoch)
+	binary.BigEndian.PutUint64(header[9:17], uint64(len(payload)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// Replica follows a Primary over TCP, applying its log to a local,
+// read-only DB.
+type Replica struct {
+	db *DB
+}
+
+// NewReplica marks db read-only and wraps it so it can follow a Primary.
+// Local calls to CreateChirp and DeleteChirp on db will fail with
+// ErrReadOnly.
+func NewReplica(db *DB) *Replica {
+	db.mux.Lock()
+	db.readOnly = true
+	db.mux.Unlock()
+	return &Replica{db: db}
+}
+
+// FollowPrimary connects to addr, sends this replica's resume point (its
+// last applied epoch and log offset), and then applies frames from the
+// primary until the connection is closed or an error occurs. Callers that
+// want to keep following after a disconnect should call it again in a
+// retry loop.
+func (r *Replica) FollowPrimary(addr string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r.db.mux.Lock()
+	epoch := r.db.epoch
+	offset, err := r.db.size()
+	r.db.mux.Unlock()
+	if err != nil {
+		return err
+	}
+
+	handshake := make([]byte, 16)
+	binary.BigEndian.PutUint64(handshake[0:8], epoch)
+	binary.BigEndian.PutUint64(handshake[8:16], uint64(offset))
+	if _, err := conn.Write(handshake); err != nil {
+		return err
+	}
+
+	for {
+		snapshot, epoch, payload, err := readFrame(conn)
+		if err != nil {
+			return err
+		}
+		if err := r.apply(snapshot, epoch, payload); err != nil {
+			return err
+		}
+	}
+}
+
+// apply writes a frame's payload into the local log, either replacing it
+// wholesale (snapshot) or appending it (delta), and brings the in-memory
+// index up to date.
+func (r *Replica) apply(snapshot bool, epoch uint64, payload []byte) error {
+	db := r.db
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	var from int64
+	if snapshot {
+		if err := db.file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := db.file.WriteAt(payload, 0); err != nil {
+			return err
+		}
+		db.index = make(map[int]int64)
+		db.epoch = epoch
+
+		// The snapshot bytes carry the primary's own header (including
+		// its codec id); re-detect it rather than trusting whatever
+		// codec this replica happened to be constructed with.
+		db.codec = nil
+		if err := db.initHeader(); err != nil {
+			return err
+		}
+		from = db.headerSize
+	} else {
+		size, err := db.size()
+		if err != nil {
+			return err
+		}
+		if _, err := db.file.WriteAt(payload, size); err != nil {
+			return err
+		}
+		from = size
+	}
+
+	maxID := 0
+	_, err := db.scanFrom(from, func(rec record, at int64) {
+		if rec.ID > maxID {
+			maxID = rec.ID
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if snapshot || maxID >= db.next {
+		db.next = maxID + 1
+	}
+	return nil
+}
+
+// readFrame reads one [mode(1) | epoch(8) | length(8) | payload] frame.
+func readFrame(r io.Reader) (snapshot bool, epoch uint64, payload []byte, err error) {
+	header := make([]byte, 17)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return false, 0, nil, err
+	}
+
+	snapshot = header[0] == 1
+	epoch = binary.BigEndian.Uint64(header[1:9])
+	length := binary.BigEndian.Uint64(header[9:17])
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return false, 0, nil, fmt.Errorf("database: truncated replication frame: %w", err)
+	}
+
+	return snapshot, epoch, payload, nil
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitForChirps polls db.GetChirps until it returns want bodies, in order,
+// or t fails the test once deadline elapses. Replication is asynchronous
+// (bounded by pollInterval), so tests can't assert on state immediately
+// after FollowPrimary starts.
+func waitForChirps(t *testing.T, db *DB, want []string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		chirps, err := db.GetChirps()
+		if err != nil {
+			t.Fatalf("GetChirps: %v", err)
+		}
+		if len(chirps) == len(want) {
+			ok := true
+			for i, body := range want {
+				if chirps[i].Body != body {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	chirps, _ := db.GetChirps()
+	t.Fatalf("timed out waiting for chirps %v, last saw %+v", want, chirps)
+}
+
+// listenOnFreePort binds a Primary's replication listener to an
+// OS-assigned port and returns its address.
+func listenOnFreePort(t *testing.T, p *Primary) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	go p.ListenReplication(addr)
+	// ListenReplication re-binds the same address; give it a moment to
+	// come up before a replica dials it.
+	time.Sleep(20 * time.Millisecond)
+	return addr
+}
+
+func TestReplicaFastForwardsFromDelta(t *testing.T) {
+	primaryDB, err := NewDB(filepath.Join(t.TempDir(), "primary.log"))
+	if err != nil {
+		t.Fatalf("NewDB(primary): %v", err)
+	}
+	if _, err := primaryDB.CreateChirp("before"); err != nil {
+		t.Fatalf("CreateChirp: %v", err)
+	}
+
+	primary := NewPrimary(primaryDB)
+	addr := listenOnFreePort(t, primary)
+
+	replicaDB, err := NewDB(filepath.Join(t.TempDir(), "replica.log"))
+	if err != nil {
+		t.Fatalf("NewDB(replica): %v", err)
+	}
+	replica := NewReplica(replicaDB)
+
+	go replica.FollowPrimary(addr)
+
+	waitForChirps(t, replicaDB, []string{"before"})
+
+	if _, err := primaryDB.CreateChirp("after"); err != nil {
+		t.Fatalf("CreateChirp: %v", err)
+	}
+	waitForChirps(t, replicaDB, []string{"before", "after"})
+
+	if _, err := replicaDB.CreateChirp("rejected"); err != ErrReadOnly {
+		t.Fatalf("CreateChirp on replica: got %v, want ErrReadOnly", err)
+	}
+}
+
+func TestReplicaResyncsWithSnapshotAfterCompact(t *testing.T) {
+	primaryDB, err := NewDB(filepath.Join(t.TempDir(), "primary.log"))
+	if err != nil {
+		t.Fatalf("NewDB(primary): %v", err)
+	}
+	for _, body := range []string{"one", "two", "three"} {
+		if _, err := primaryDB.CreateChirp(body); err != nil {
+			t.Fatalf("CreateChirp(%q): %v", body, err)
+		}
+	}
+	if err := primaryDB.DeleteChirp(2); err != nil {
+		t.Fatalf("DeleteChirp: %v", err)
+	}
+
+	primary := NewPrimary(primaryDB)
+	addr := listenOnFreePort(t, primary)
+
+	replicaDB, err := NewDB(filepath.Join(t.TempDir(), "replica.log"))
+	if err != nil {
+		t.Fatalf("NewDB(replica): %v", err)
+	}
+	replica := NewReplica(replicaDB)
+
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			replica.FollowPrimary(addr)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	defer close(stop)
+
+	waitForChirps(t, replicaDB, []string{"one", "three"})
+
+	// Compact bumps the primary's epoch, so the already-connected replica
+	// must notice the mismatch and fall back to a full snapshot rather
+	// than trying to apply a delta at a now-meaningless offset.
+	if err := primaryDB.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if _, err := primaryDB.CreateChirp("four"); err != nil {
+		t.Fatalf("CreateChirp after compact: %v", err)
+	}
+
+	waitForChirps(t, replicaDB, []string{"one", "three", "four"})
+}